@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2021 yedf. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+// Command dtm is the dtm server binary. Today it exposes the schema
+// migration subcommands -- `dtm migrate up/down/force` lets an operator
+// apply or roll back dtm_trans_global/dtm_trans_branch/dtm_barrier DDL
+// without reading release notes for out-of-band changes -- and `dtm listen`,
+// which runs the postgres NOTIFY/LISTEN branch wake-up listener standalone.
+// This snapshot has no branch-write code path or cron/scheduling queue to
+// feed gids into, so `dtm listen` only logs the gids it receives; a real
+// dtm server would push them into its scheduling queue instead
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	"github.com/yedf/dtm/dtmcli/dtmimp"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+	switch os.Args[1] {
+	case "migrate":
+		runMigrate(os.Args[2:])
+	case "listen":
+		runListen(os.Args[2:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dtm migrate up|down [flags]")
+	fmt.Fprintln(os.Stderr, "       dtm migrate force [flags] <version>")
+	fmt.Fprintln(os.Stderr, "       dtm listen [flags]")
+	fmt.Fprintln(os.Stderr, "flags: --driver --host --port --user --password --database (default from DTM_STORE_* env vars)")
+	fmt.Fprintln(os.Stderr, "       listen takes no --driver flag, it always uses postgres")
+}
+
+func runMigrate(args []string) {
+	if len(args) < 1 {
+		usage()
+		os.Exit(1)
+	}
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	driver := fs.String("driver", os.Getenv("DTM_STORE_DRIVER"), "mysql or postgres")
+	host := fs.String("host", os.Getenv("DTM_STORE_HOST"), "db host")
+	port := fs.String("port", os.Getenv("DTM_STORE_PORT"), "db port")
+	user := fs.String("user", os.Getenv("DTM_STORE_USER"), "db user")
+	password := fs.String("password", os.Getenv("DTM_STORE_PASSWORD"), "db password")
+	database := fs.String("database", os.Getenv("DTM_STORE_DATABASE"), "db name")
+	fs.Parse(args[1:])
+
+	conf := map[string]string{
+		"driver":   *driver,
+		"host":     *host,
+		"port":     *port,
+		"user":     *user,
+		"password": *password,
+		"database": *database,
+	}
+	db, err := dtmimp.StandaloneDB(conf)
+	dtmimp.FatalIfError(err)
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		dtmimp.FatalIfError(dtmimp.MigrateUp(db, *driver))
+	case "down":
+		dtmimp.FatalIfError(dtmimp.MigrateDown(db, *driver))
+	case "force":
+		if len(fs.Args()) < 1 {
+			fmt.Fprintln(os.Stderr, "usage: dtm migrate force [flags] <version>")
+			os.Exit(1)
+		}
+		version := dtmimp.MustAtoi(fs.Arg(0))
+		dtmimp.FatalIfError(dtmimp.Force(db, *driver, version))
+	default:
+		usage()
+		os.Exit(1)
+	}
+}
+
+// runListen runs the postgres NOTIFY/LISTEN branch wake-up path standalone,
+// logging each gid it receives. This is the construct-and-start half of the
+// low-latency wake-up path; the other half, calling dtmimp.NotifyBranch
+// after a branch row is written, lives in the branch-write code path, which
+// isn't part of this snapshot
+func runListen(args []string) {
+	fs := flag.NewFlagSet("listen", flag.ExitOnError)
+	host := fs.String("host", os.Getenv("DTM_STORE_HOST"), "db host")
+	port := fs.String("port", os.Getenv("DTM_STORE_PORT"), "db port")
+	user := fs.String("user", os.Getenv("DTM_STORE_USER"), "db user")
+	password := fs.String("password", os.Getenv("DTM_STORE_PASSWORD"), "db password")
+	database := fs.String("database", os.Getenv("DTM_STORE_DATABASE"), "db name")
+	fs.Parse(args)
+
+	conf := map[string]string{
+		"driver":   "postgres",
+		"host":     *host,
+		"port":     *port,
+		"user":     *user,
+		"password": *password,
+		"database": *database,
+	}
+	dsn, err := dtmimp.GetDsn(conf)
+	dtmimp.FatalIfError(err)
+
+	dtmimp.EnablePgNotify = true
+	listener := dtmimp.NewPgNotifyListener(dsn, func(gid string) {
+		dtmimp.Logf("pg notify woke branch gid=%s", gid)
+	})
+	dtmimp.FatalIfError(listener.Run(context.Background()))
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2021 yedf. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package dtmimp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDsnUnknownDriver(t *testing.T) {
+	assert.Error(t, CatchP(func() {
+		GetDsn(map[string]string{"driver": "no-driver"})
+	}))
+}
+
+func TestGetDsnPostgresDefaultsToSslDisable(t *testing.T) {
+	dsn, err := GetDsn(map[string]string{
+		"driver": "postgres", "host": "h", "port": "5432", "user": "u", "password": "p", "database": "d",
+	})
+	assert.Equal(t, true, err == nil)
+	assert.True(t, strings.Contains(dsn, "sslmode=disable"))
+}
+
+func TestGetDsnPostgresMissingCertFile(t *testing.T) {
+	_, err := GetDsn(map[string]string{
+		"driver": "postgres", "host": "h", "port": "5432", "user": "u", "password": "p", "database": "d",
+		"sslrootcert": "/no/such/file",
+	})
+	assert.Error(t, err)
+}
+
+func TestCheckKeyFilePermissionRejectsWorldReadable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client.key")
+	if err := os.WriteFile(path, []byte("key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, checkKeyFilePermission(path))
+}
+
+func TestCheckKeyFilePermissionAcceptsOwnerOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client.key")
+	if err := os.WriteFile(path, []byte("key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, true, checkKeyFilePermission(path) == nil)
+}
+
+func TestMaybeRegisterMysqlTLSRequiresBothCertAndKey(t *testing.T) {
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "client.crt")
+	if err := os.WriteFile(cert, []byte("cert"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := maybeRegisterMysqlTLS(map[string]string{"database": "d", "sslcert": cert})
+	assert.Error(t, err)
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2021 yedf. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package dtmimp
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Logger is the logging interface used throughout dtmimp. WithFields
+// returns a Logger that prepends kv (alternating key, value) to every
+// subsequent call, letting branch-level code attach gid/branch_id/op/
+// trans_type once and log consistently from there on
+type Logger interface {
+	Infof(fmt string, args ...interface{})
+	Errorf(fmt string, args ...interface{})
+	WithFields(kv ...interface{}) Logger
+}
+
+// zapLogger adapts *zap.SugaredLogger to Logger
+type zapLogger struct {
+	sugared *zap.SugaredLogger
+}
+
+func (l *zapLogger) Infof(fmt string, args ...interface{})  { l.sugared.Infof(fmt, args...) }
+func (l *zapLogger) Errorf(fmt string, args ...interface{}) { l.sugared.Errorf(fmt, args...) }
+func (l *zapLogger) WithFields(kv ...interface{}) Logger {
+	return &zapLogger{sugared: l.sugared.With(kv...)}
+}
+
+var defaultLogger Logger
+
+func init() {
+	InitLog()
+}
+
+// InitLog (re)builds the default zap-backed Logger
+func InitLog() {
+	config := zap.NewProductionConfig()
+	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	if os.Getenv("DTM_DEBUG") != "" {
+		config.Encoding = "console"
+		config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	}
+	p, err := config.Build()
+	if err != nil {
+		log.Fatal("create logger failed: ", err)
+	}
+	defaultLogger = &zapLogger{sugared: p.Sugar()}
+}
+
+// SetLogger lets callers replace the default logger with their own zap/logr
+// adapter without forking dtm
+func SetLogger(l Logger) {
+	defaultLogger = l
+}
+
+// Logf 输出日志, a thin shim over the default Logger kept for backwards
+// compatibility with code written against the old package-level API
+func Logf(fmt string, args ...interface{}) {
+	defaultLogger.Infof(fmt, args...)
+}
+
+// LogRedf 采用红色打印错误类信息, see Logf
+func LogRedf(fmt string, args ...interface{}) {
+	defaultLogger.Errorf(fmt, args...)
+}
+
+type loggerCtxKey struct{}
+
+// ContextWithLogger returns a copy of ctx carrying l, for handlers that want
+// every downstream log line to automatically include fields set via
+// l.WithFields
+func ContextWithLogger(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerCtxKey{}, l)
+}
+
+// LoggerFromContext returns the Logger attached to ctx by ContextWithLogger,
+// or the default logger if none was attached
+func LoggerFromContext(ctx context.Context) Logger {
+	if l, ok := ctx.Value(loggerCtxKey{}).(Logger); ok {
+		return l
+	}
+	return defaultLogger
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright (c) 2021 yedf. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package dtmimp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+// fakeLogger records the kv passed to WithFields instead of logging
+type fakeLogger struct {
+	fields []interface{}
+}
+
+func (f *fakeLogger) Infof(fmt string, args ...interface{})  {}
+func (f *fakeLogger) Errorf(fmt string, args ...interface{}) {}
+func (f *fakeLogger) WithFields(kv ...interface{}) Logger {
+	return &fakeLogger{fields: append(append([]interface{}{}, f.fields...), kv...)}
+}
+
+func TestWithBranchFieldsAttachesAllFour(t *testing.T) {
+	l := WithBranchFields(&fakeLogger{}, "gid1", "branch1", "op1", "saga")
+	fl := l.(*fakeLogger)
+	assert.Equal(t, []interface{}{"gid", "gid1", "branch_id", "branch1", "op", "op1", "trans_type", "saga"}, fl.fields)
+}
+
+func TestContextWithBranchLoggerExtractsMetadata(t *testing.T) {
+	old := defaultLogger
+	defaultLogger = &fakeLogger{}
+	defer func() { defaultLogger = old }()
+
+	md := metadata.MD{
+		GidHeader:       []string{"gid1"},
+		BranchIDHeader:  []string{"branch1"},
+		OpHeader:        []string{"op1"},
+		TransTypeHeader: []string{"saga"},
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	ctx = contextWithBranchLogger(ctx)
+
+	l := LoggerFromContext(ctx).(*fakeLogger)
+	assert.Equal(t, []interface{}{"gid", "gid1", "branch_id", "branch1", "op", "op1", "trans_type", "saga"}, l.fields)
+}
@@ -0,0 +1,309 @@
+/*
+ * Copyright (c) 2021 yedf. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package dtmimp
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+const schemaMigrationsTable = "schema_migrations"
+
+// advisoryLockID is a fixed lock key used so every dtm process migrating the
+// same database contends on the same advisory lock, regardless of dsn.
+const advisoryLockID = 20210101
+
+var migrationNameRe = regexp.MustCompile(`^(\d+)_.*\.(up|down)\.sql$`)
+
+// migration is a single numbered up/down pair loaded from migrations/<driver>
+type migration struct {
+	version int
+	up      string
+	down    string
+}
+
+// loadMigrations reads the embedded .sql files for driver and returns them
+// sorted by version ascending
+func loadMigrations(driver string) ([]migration, error) {
+	dir := "migrations/" + driver
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations embedded for driver: %s: %w", driver, err)
+	}
+	byVersion := map[int]*migration{}
+	for _, e := range entries {
+		m := migrationNameRe.FindStringSubmatch(e.Name())
+		if m == nil {
+			continue
+		}
+		version := MustAtoi(m[1])
+		b, err := migrationFiles.ReadFile(dir + "/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		if byVersion[version] == nil {
+			byVersion[version] = &migration{version: version}
+		}
+		if m[2] == "up" {
+			byVersion[version].up = string(b)
+		} else {
+			byVersion[version].down = string(b)
+		}
+	}
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// ensureSchemaMigrationsTable creates the version-tracking table if absent
+func ensureSchemaMigrationsTable(db *sql.DB, driver string) error {
+	ddl := map[string]string{
+		"mysql":    `create table if not exists ` + schemaMigrationsTable + `(version bigint not null primary key, dirty bool not null)`,
+		"postgres": `create table if not exists ` + schemaMigrationsTable + `(version bigint primary key, dirty boolean not null)`,
+	}[driver]
+	PanicIf(ddl == "", fmt.Errorf("unknow driver: %s", driver))
+	_, err := db.Exec(ddl)
+	return err
+}
+
+// acquireMigrationLock takes a session-level advisory lock on conn so only
+// one process applies migrations at a time. Both GET_LOCK and
+// pg_advisory_lock are scoped to the session that took them, so the caller
+// must keep running every statement of the migration on this same conn
+// until releaseMigrationLock is called
+func acquireMigrationLock(ctx context.Context, conn *sql.Conn, driver string) error {
+	switch driver {
+	case "mysql":
+		var got int
+		err := conn.QueryRowContext(ctx, "select GET_LOCK(?, 10)", fmt.Sprintf("dtm_migrate_%d", advisoryLockID)).Scan(&got)
+		if err != nil {
+			return err
+		}
+		if got != 1 {
+			return fmt.Errorf("could not acquire migration lock: GET_LOCK timed out or failed")
+		}
+		return nil
+	case "postgres":
+		// pg_advisory_lock blocks until acquired, so a nil error is sufficient
+		_, err := conn.ExecContext(ctx, "select pg_advisory_lock($1)", advisoryLockID)
+		return err
+	}
+	return fmt.Errorf("unknow driver: %s", driver)
+}
+
+// releaseMigrationLock releases the lock taken by acquireMigrationLock. It
+// must run on the same conn that acquired the lock
+func releaseMigrationLock(ctx context.Context, conn *sql.Conn, driver string) error {
+	switch driver {
+	case "mysql":
+		_, err := conn.ExecContext(ctx, "select RELEASE_LOCK(?)", fmt.Sprintf("dtm_migrate_%d", advisoryLockID))
+		return err
+	case "postgres":
+		_, err := conn.ExecContext(ctx, "select pg_advisory_unlock($1)", advisoryLockID)
+		return err
+	}
+	return fmt.Errorf("unknow driver: %s", driver)
+}
+
+// currentSchemaVersion returns the last recorded version and its dirty flag,
+// or version 0, dirty false if no row has been written yet
+func currentSchemaVersion(ctx context.Context, conn *sql.Conn, driver string) (int, bool, error) {
+	row := conn.QueryRowContext(ctx, "select version, dirty from "+schemaMigrationsTable+" order by version desc limit 1")
+	var version int
+	var dirty bool
+	err := row.Scan(&version, &dirty)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// schemaVersionInsertSQL returns the schema_migrations insert statement using
+// driver's own placeholder syntax. driver is always known explicitly by the
+// caller here, so this intentionally does not go through
+// GetDBSpecial()/GetPlaceHoldSQL, which key off the process-wide
+// currentDBType and can silently disagree with the driver a migration is
+// actually running against
+func schemaVersionInsertSQL(driver string) string {
+	return map[string]string{
+		"mysql":    "insert into " + schemaMigrationsTable + "(version, dirty) values(?, ?)",
+		"postgres": "insert into " + schemaMigrationsTable + "(version, dirty) values($1, $2)",
+	}[driver]
+}
+
+// connDB adapts a *sql.Conn to the DB interface (Exec without an explicit
+// context parameter) so it can be passed to setSchemaVersion alongside
+// *sql.DB and *sql.Tx
+type connDB struct {
+	ctx  context.Context
+	conn *sql.Conn
+}
+
+func (c connDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(c.ctx, query, args...)
+}
+
+func setSchemaVersion(db DB, driver string, version int, dirty bool) error {
+	if _, err := db.Exec("delete from " + schemaMigrationsTable); err != nil {
+		return err
+	}
+	insert := schemaVersionInsertSQL(driver)
+	PanicIf(insert == "", fmt.Errorf("unknow driver: %s", driver))
+	_, err := db.Exec(insert, version, dirty)
+	return err
+}
+
+// Migrate applies all pending up migrations for driver against db. It takes
+// an advisory lock so concurrent dtm instances don't race, and marks the
+// schema dirty if a migration fails partway so a follow-up run can Force it
+func Migrate(db *sql.DB, driver string) error {
+	return MigrateUp(db, driver)
+}
+
+// MigrateUp applies all pending up migrations, see Migrate
+func MigrateUp(db *sql.DB, driver string) error {
+	if err := ensureSchemaMigrationsTable(db, driver); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := acquireMigrationLock(ctx, conn, driver); err != nil {
+		return err
+	}
+	defer releaseMigrationLock(ctx, conn, driver)
+
+	version, dirty, err := currentSchemaVersion(ctx, conn, driver)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("database schema is dirty at version %d, run Force to repair it", version)
+	}
+	migrations, err := loadMigrations(driver)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		if err := applyMigration(ctx, conn, driver, m.version, m.up); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateDown rolls back the single most recently applied migration
+func MigrateDown(db *sql.DB, driver string) error {
+	if err := ensureSchemaMigrationsTable(db, driver); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := acquireMigrationLock(ctx, conn, driver); err != nil {
+		return err
+	}
+	defer releaseMigrationLock(ctx, conn, driver)
+
+	version, dirty, err := currentSchemaVersion(ctx, conn, driver)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("database schema is dirty at version %d, run Force to repair it", version)
+	}
+	if version == 0 {
+		return nil
+	}
+	migrations, err := loadMigrations(driver)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.version == version {
+			return applyMigrationDown(ctx, conn, driver, migrations, m)
+		}
+	}
+	return fmt.Errorf("no migration found for version %d", version)
+}
+
+// applyMigration runs a single up migration and records its version, both on
+// conn, inside one transaction so the advisory lock held on conn actually
+// covers the statements it is meant to protect
+func applyMigration(ctx context.Context, conn *sql.Conn, driver string, version int, script string) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		// mark dirty outside the failed transaction so a follow-up Force can repair it
+		setSchemaVersion(connDB{ctx, conn}, driver, version, true)
+		return fmt.Errorf("migration %d failed: %w", version, err)
+	}
+	if err := setSchemaVersion(tx, driver, version, false); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func applyMigrationDown(ctx context.Context, conn *sql.Conn, driver string, migrations []migration, m migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, m.down); err != nil {
+		tx.Rollback()
+		setSchemaVersion(connDB{ctx, conn}, driver, m.version, true)
+		return fmt.Errorf("migration %d down failed: %w", m.version, err)
+	}
+	prev := 0
+	for _, p := range migrations {
+		if p.version < m.version && p.version > prev {
+			prev = p.version
+		}
+	}
+	if err := setSchemaVersion(tx, driver, prev, false); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Force sets the schema_migrations version without running any migration,
+// clearing the dirty flag left behind by a partially applied migration
+func Force(db *sql.DB, driver string, version int) error {
+	if err := ensureSchemaMigrationsTable(db, driver); err != nil {
+		return err
+	}
+	return setSchemaVersion(db, driver, version, false)
+}
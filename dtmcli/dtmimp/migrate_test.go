@@ -0,0 +1,35 @@
+/*
+ * Copyright (c) 2021 yedf. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package dtmimp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMigrationsSortedByVersion(t *testing.T) {
+	for _, driver := range []string{"mysql", "postgres"} {
+		migrations, err := loadMigrations(driver)
+		if err != nil {
+			t.Fatalf("loadMigrations(%s) failed: %v", driver, err)
+		}
+		assert.True(t, len(migrations) > 0)
+		for i := 1; i < len(migrations); i++ {
+			assert.True(t, migrations[i].version > migrations[i-1].version)
+		}
+		for _, m := range migrations {
+			assert.True(t, m.up != "")
+			assert.True(t, m.down != "")
+		}
+	}
+}
+
+func TestLoadMigrationsUnknownDriver(t *testing.T) {
+	_, err := loadMigrations("no-driver")
+	assert.Error(t, err)
+}
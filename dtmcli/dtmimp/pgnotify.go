@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2021 yedf. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package dtmimp
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PgNotifyChannel is the postgres NOTIFY channel used to wake up branches
+const PgNotifyChannel = "dtm_branch"
+
+// EnablePgNotify turns on the NOTIFY-driven low-latency branch wake-up path
+// for postgres. When false, branches are only progressed on the next poll
+// tick
+var EnablePgNotify = false
+
+// pgNotifyCoalesceWindow is how long duplicate gids are suppressed for
+// before being delivered again, avoiding a wake-up storm when a branch is
+// updated several times in quick succession
+const pgNotifyCoalesceWindow = 200 * time.Millisecond
+
+// NotifyBranch issues a best-effort `NOTIFY dtm_branch, '<gid>'`. Callers
+// that insert or update a branch row are expected to invoke this right
+// after that write; it is a no-op unless EnablePgNotify is set, and a
+// failure here is intentionally non-fatal so the caller keeps relying on
+// the existing poll loop as a fallback.
+//
+// This is a postgres-only statement, so its placeholder is hardcoded to
+// postgres's `$1` syntax rather than routed through
+// GetDBSpecial().GetPlaceHoldSQL, which keys off the process-wide
+// currentDBType: a caller running against postgres while currentDBType
+// is set to mysql (or never set) would otherwise send a literal `?` and
+// the NOTIFY would fail. See the same reasoning in migrate.go's
+// schemaVersionInsertSQL.
+func NotifyBranch(db DB, gid string) {
+	if !EnablePgNotify {
+		return
+	}
+	if _, err := db.Exec("select pg_notify('"+PgNotifyChannel+"', $1)", gid); err != nil {
+		LogRedf("pg_notify for gid %s failed, falling back to polling: %v", gid, err)
+	}
+}
+
+// PgNotifyListener listens for dtm_branch notifications on a dedicated
+// connection and forwards deduplicated gids to OnGid. `dtm listen`
+// (cmd/dtm/main.go) constructs and runs one standalone, logging the gids it
+// receives; a real dtm server's cron/scheduling queue isn't part of this
+// snapshot, so OnGid remains the integration point a server package should
+// use to push received gids into that queue instead of just logging them
+type PgNotifyListener struct {
+	Dsn          string
+	OnGid        func(gid string)
+	MinReconnect time.Duration
+	MaxReconnect time.Duration
+	mu           sync.Mutex
+	pending      map[string]*pgNotifyPending
+}
+
+// pgNotifyPending tracks the coalesce timer for a gid's leading-edge
+// delivery, and whether a further notification arrived while it was running
+type pgNotifyPending struct {
+	timer    *time.Timer
+	trailing bool
+}
+
+// NewPgNotifyListener creates a listener with sane reconnect backoff bounds
+func NewPgNotifyListener(dsn string, onGid func(gid string)) *PgNotifyListener {
+	return &PgNotifyListener{
+		Dsn:          dsn,
+		OnGid:        onGid,
+		MinReconnect: time.Second,
+		MaxReconnect: time.Minute,
+		pending:      map[string]*pgNotifyPending{},
+	}
+}
+
+// Run opens a LISTEN connection and blocks, delivering gids to OnGid until
+// ctx is done. pq.Listener already reconnects internally with exponential
+// backoff between MinReconnect and MaxReconnect and re-issues LISTEN on the
+// new connection itself, so Run only needs to relay notifications
+func (l *PgNotifyListener) Run(ctx context.Context) error {
+	listener := pq.NewListener(l.Dsn, l.MinReconnect, l.MaxReconnect, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			LogRedf("pg notify listener event %v: %v", event, err)
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(PgNotifyChannel); err != nil {
+		return err
+	}
+	Logf("pg notify listener subscribed to %s", PgNotifyChannel)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case notification := <-listener.Notify:
+			if notification == nil {
+				// nil notification means the connection was lost; pq.Listener
+				// is already reconnecting and will re-establish LISTEN itself
+				continue
+			}
+			l.deliver(notification.Extra)
+		}
+	}
+}
+
+// deliver calls OnGid immediately for the first notification of a given gid,
+// then coalesces repeats of that same gid for pgNotifyCoalesceWindow: a
+// repeat doesn't trigger its own call, but is remembered so one trailing
+// OnGid fires once the window closes, picking up the state change the
+// repeat represented instead of dropping it until the next poll tick. A gid
+// that sees no repeats during the window incurs only the one leading call
+func (l *PgNotifyListener) deliver(gid string) {
+	l.mu.Lock()
+	if p, ok := l.pending[gid]; ok {
+		p.trailing = true
+		l.mu.Unlock()
+		return
+	}
+	p := &pgNotifyPending{}
+	p.timer = time.AfterFunc(pgNotifyCoalesceWindow, func() {
+		l.mu.Lock()
+		delete(l.pending, gid)
+		trailing := p.trailing
+		l.mu.Unlock()
+		if trailing {
+			l.OnGid(gid)
+		}
+	})
+	l.pending[gid] = p
+	l.mu.Unlock()
+	l.OnGid(gid)
+}
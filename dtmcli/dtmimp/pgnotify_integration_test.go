@@ -0,0 +1,56 @@
+/*
+ * Copyright (c) 2021 yedf. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package dtmimp
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// TestPgNotifyListenerRoundTrip exercises a real NOTIFY/LISTEN round trip
+// against postgres. It is skipped unless DTM_TEST_POSTGRES_DSN is set, since
+// this snapshot has no test harness that brings up a real postgres
+func TestPgNotifyListenerRoundTrip(t *testing.T) {
+	dsn := os.Getenv("DTM_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("DTM_TEST_POSTGRES_DSN not set, skipping real postgres NOTIFY/LISTEN round trip")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	oldEnable := EnablePgNotify
+	EnablePgNotify = true
+	defer func() { EnablePgNotify = oldEnable }()
+
+	received := make(chan string, 1)
+	listener := NewPgNotifyListener(dsn, func(gid string) { received <- gid })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go listener.Run(ctx)
+
+	// give the listener a moment to subscribe before notifying
+	time.Sleep(500 * time.Millisecond)
+	NotifyBranch(db, "integration-test-gid")
+
+	select {
+	case gid := <-received:
+		if gid != "integration-test-gid" {
+			t.Fatalf("got gid %q, want integration-test-gid", gid)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for NOTIFY to be delivered")
+	}
+}
@@ -0,0 +1,57 @@
+/*
+ * Copyright (c) 2021 yedf. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package dtmimp
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPgNotifyListenerCoalesce exercises the in-process dedup logic only;
+// a real `NOTIFY`/`LISTEN` round trip needs a live postgres and isn't
+// covered here. gid1's repeat should not trigger its own immediate call,
+// but should still surface as one trailing delivery once the coalesce
+// window closes, rather than being dropped
+func TestPgNotifyListenerCoalesce(t *testing.T) {
+	var mu sync.Mutex
+	var delivered []string
+	l := NewPgNotifyListener("", func(gid string) {
+		mu.Lock()
+		delivered = append(delivered, gid)
+		mu.Unlock()
+	})
+
+	l.deliver("gid1")
+	l.deliver("gid1")
+	l.deliver("gid2")
+
+	time.Sleep(pgNotifyCoalesceWindow * 2)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"gid1", "gid2", "gid1"}, delivered)
+}
+
+// TestPgNotifyListenerDeliversLeadingEdgeImmediately makes sure the first
+// notification for a gid reaches OnGid right away rather than waiting out
+// pgNotifyCoalesceWindow; only repeats of that gid should be coalesced
+func TestPgNotifyListenerDeliversLeadingEdgeImmediately(t *testing.T) {
+	received := make(chan string, 1)
+	l := NewPgNotifyListener("", func(gid string) { received <- gid })
+
+	l.deliver("gid1")
+
+	select {
+	case gid := <-received:
+		assert.Equal(t, "gid1", gid)
+	case <-time.After(pgNotifyCoalesceWindow / 2):
+		t.Fatal("deliver did not call OnGid before the coalesce window elapsed")
+	}
+}
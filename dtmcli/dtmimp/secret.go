@@ -0,0 +1,208 @@
+/*
+ * Copyright (c) 2021 yedf. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package dtmimp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// SecretProvider resolves a secret_ref such as "env:MYVAR" or
+// "vault:secret/data/dtm/db" into the config fields it stands for, letting
+// PooledDB/StandaloneDB keep real credentials out of conf maps and config
+// files
+type SecretProvider interface {
+	Resolve(ref string) (map[string]string, error)
+}
+
+// EnvSecretProvider resolves "env:MYVAR" by reading MYVAR as a JSON object
+type EnvSecretProvider struct{}
+
+// Resolve implements SecretProvider
+func (EnvSecretProvider) Resolve(ref string) (map[string]string, error) {
+	name := strings.TrimPrefix(ref, "env:")
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("secret env var not set: %s", name)
+	}
+	return parseSecretJSON(v)
+}
+
+// FileSecretProvider resolves "file:/path/to/secret" by reading the file's
+// content as a JSON object, e.g. a kubernetes secret volume mount
+type FileSecretProvider struct{}
+
+// Resolve implements SecretProvider
+func (FileSecretProvider) Resolve(ref string) (map[string]string, error) {
+	path := strings.TrimPrefix(ref, "file:")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read secret file %s failed: %w", path, err)
+	}
+	return parseSecretJSON(string(b))
+}
+
+// VaultSecretProvider resolves "vault:secret/data/dtm/db" against a Vault
+// KV v2 endpoint, authenticating with a static token or AppRole credentials
+type VaultSecretProvider struct {
+	Addr       string
+	Token      string
+	RoleID     string
+	SecretID   string
+	HTTPClient *http.Client
+}
+
+// NewVaultSecretProviderFromEnv builds a VaultSecretProvider from the
+// conventional VAULT_ADDR/VAULT_TOKEN/VAULT_ROLE_ID/VAULT_SECRET_ID env vars
+func NewVaultSecretProviderFromEnv() *VaultSecretProvider {
+	return &VaultSecretProvider{
+		Addr:       os.Getenv("VAULT_ADDR"),
+		Token:      os.Getenv("VAULT_TOKEN"),
+		RoleID:     os.Getenv("VAULT_ROLE_ID"),
+		SecretID:   os.Getenv("VAULT_SECRET_ID"),
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// Resolve implements SecretProvider
+func (v *VaultSecretProvider) Resolve(ref string) (map[string]string, error) {
+	path := strings.TrimPrefix(ref, "vault:")
+	if v.Addr == "" {
+		return nil, fmt.Errorf("vault secret_ref %s used but VAULT_ADDR is not set", ref)
+	}
+	token, err := v.resolveToken()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(v.Addr, "/")+"/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := v.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault request for %s failed: %w", ref, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault request for %s returned %d: %s", ref, resp.StatusCode, body)
+	}
+	var parsed struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("vault response for %s is not valid KV v2 JSON: %w", ref, err)
+	}
+	return parsed.Data.Data, nil
+}
+
+// resolveToken returns the configured static token, or logs in via AppRole
+// when RoleID/SecretID are set instead
+func (v *VaultSecretProvider) resolveToken() (string, error) {
+	if v.Token != "" {
+		return v.Token, nil
+	}
+	if v.RoleID == "" {
+		return "", fmt.Errorf("vault secret provider has no token and no AppRole credentials")
+	}
+	loginBody, err := json.Marshal(map[string]string{"role_id": v.RoleID, "secret_id": v.SecretID})
+	if err != nil {
+		return "", err
+	}
+	resp, err := v.HTTPClient.Post(strings.TrimRight(v.Addr, "/")+"/v1/auth/approle/login", "application/json", strings.NewReader(string(loginBody)))
+	if err != nil {
+		return "", fmt.Errorf("vault approle login failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var parsed struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return "", fmt.Errorf("vault approle login response is not valid JSON: %w", err)
+	}
+	if parsed.Auth.ClientToken == "" {
+		return "", fmt.Errorf("vault approle login returned no client_token: %s", body)
+	}
+	return parsed.Auth.ClientToken, nil
+}
+
+func parseSecretJSON(s string) (map[string]string, error) {
+	m := map[string]string{}
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return nil, fmt.Errorf("secret value is not a JSON object of strings: %w", err)
+	}
+	return m, nil
+}
+
+// ResolveSecretRef dispatches a secret_ref to the SecretProvider matching
+// its prefix (env:, file:, vault:)
+func ResolveSecretRef(ref string) (map[string]string, error) {
+	switch {
+	case strings.HasPrefix(ref, "env:"):
+		return EnvSecretProvider{}.Resolve(ref)
+	case strings.HasPrefix(ref, "file:"):
+		return FileSecretProvider{}.Resolve(ref)
+	case strings.HasPrefix(ref, "vault:"):
+		return NewVaultSecretProviderFromEnv().Resolve(ref)
+	}
+	return nil, fmt.Errorf("unrecognized secret_ref: %s", ref)
+}
+
+// isDynamicSecretRef reports whether ref points at a backend whose
+// credentials can change without dtm being restarted, and so should be
+// periodically re-resolved and rotated. file: is included because its
+// primary use case, a kubernetes secret volume mount, is updated in place
+// by the kubelet on the same running container
+func isDynamicSecretRef(ref string) bool {
+	return strings.HasPrefix(ref, "vault:") || strings.HasPrefix(ref, "file:")
+}
+
+// resolveConf returns conf unchanged when it has no secret_ref, or a new map
+// with secret_ref resolved, merged in and removed, so a second resolveConf
+// call on the result (e.g. from StandaloneDB after PooledDB already
+// resolved it) is a no-op instead of a second network round trip
+func resolveConf(conf map[string]string) (map[string]string, error) {
+	ref := conf["secret_ref"]
+	if ref == "" {
+		return conf, nil
+	}
+	secret, err := ResolveSecretRef(ref)
+	if err != nil {
+		return nil, fmt.Errorf("resolve secret_ref %s failed: %w", ref, err)
+	}
+	return mergeSecret(conf, secret), nil
+}
+
+// mergeSecret overlays secret onto a copy of conf and drops secret_ref,
+// since the credentials it pointed to are now resolved into the map
+func mergeSecret(conf map[string]string, secret map[string]string) map[string]string {
+	merged := make(map[string]string, len(conf)+len(secret))
+	for k, v := range conf {
+		merged[k] = v
+	}
+	for k, v := range secret {
+		merged[k] = v
+	}
+	delete(merged, "secret_ref")
+	return merged
+}
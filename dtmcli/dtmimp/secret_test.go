@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2021 yedf. All rights reserved.
+ * Use of this source code is governed by a BSD-style
+ * license that can be found in the LICENSE file.
+ */
+
+package dtmimp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSecretJSON(t *testing.T) {
+	m, err := parseSecretJSON(`{"user":"u","password":"p"}`)
+	assert.Equal(t, true, err == nil)
+	assert.Equal(t, "u", m["user"])
+	assert.Equal(t, "p", m["password"])
+
+	_, err = parseSecretJSON("not json")
+	assert.Error(t, err)
+}
+
+func TestMergeSecretDropsSecretRefAndOverridesConf(t *testing.T) {
+	conf := map[string]string{"user": "placeholder", "host": "h", "secret_ref": "env:X"}
+	merged := mergeSecret(conf, map[string]string{"user": "real", "password": "real-pw"})
+	assert.Equal(t, "real", merged["user"])
+	assert.Equal(t, "real-pw", merged["password"])
+	assert.Equal(t, "h", merged["host"])
+	_, hasRef := merged["secret_ref"]
+	assert.Equal(t, false, hasRef)
+	// original conf must be untouched
+	assert.Equal(t, "placeholder", conf["user"])
+}
+
+func TestResolveConfNoSecretRefReturnsSameMap(t *testing.T) {
+	conf := map[string]string{"user": "u"}
+	resolved, err := resolveConf(conf)
+	assert.Equal(t, true, err == nil)
+	assert.Equal(t, "u", resolved["user"])
+}
+
+func TestResolveSecretRefDispatch(t *testing.T) {
+	_, err := ResolveSecretRef("unknown:foo")
+	assert.Error(t, err)
+
+	t.Setenv("DTM_TEST_SECRET", `{"user":"u","password":"p"}`)
+	m, err := ResolveSecretRef("env:DTM_TEST_SECRET")
+	assert.Equal(t, true, err == nil)
+	assert.Equal(t, "u", m["user"])
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secret.json")
+	if err := os.WriteFile(path, []byte(`{"user":"u2"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	m, err = ResolveSecretRef("file:" + path)
+	assert.Equal(t, true, err == nil)
+	assert.Equal(t, "u2", m["user"])
+}
+
+func TestIsDynamicSecretRef(t *testing.T) {
+	assert.Equal(t, true, isDynamicSecretRef("vault:secret/data/dtm/db"))
+	assert.Equal(t, true, isDynamicSecretRef("file:/run/secrets/db"))
+	assert.Equal(t, false, isDynamicSecretRef("env:MYVAR"))
+}
@@ -7,21 +7,22 @@
 package dtmimp
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"runtime"
 	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-resty/resty/v2"
-	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
+	"github.com/go-sql-driver/mysql"
 )
 
 // AsError wrap a panic value as an error
@@ -119,36 +120,6 @@ func MustRemarshal(from interface{}, to interface{}) {
 	E2P(err)
 }
 
-var logger *zap.SugaredLogger = nil
-
-func init() {
-	InitLog()
-}
-
-func InitLog() {
-	config := zap.NewProductionConfig()
-	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-	if os.Getenv("DTM_DEBUG") != "" {
-		config.Encoding = "console"
-		config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
-	}
-	p, err := config.Build()
-	if err != nil {
-		log.Fatal("create logger failed: ", err)
-	}
-	logger = p.Sugar()
-}
-
-// Logf 输出日志
-func Logf(fmt string, args ...interface{}) {
-	logger.Infof(fmt, args...)
-}
-
-// LogRedf 采用红色打印错误类信息
-func LogRedf(fmt string, args ...interface{}) {
-	logger.Errorf(fmt, args...)
-}
-
 // FatalExitFunc Fatal退出函数，测试时被替换
 var FatalExitFunc = func() { os.Exit(1) }
 
@@ -188,26 +159,148 @@ func MayReplaceLocalhost(host string) string {
 
 var sqlDbs sync.Map
 
+// secretRotationInterval is how often a dynamic secret_ref (vault: or
+// file:, see isDynamicSecretRef) is re-resolved to pick up rotated credentials
+var secretRotationInterval = 5 * time.Minute
+
+// secretRotationDrainGrace is how long a rotated-out *sql.DB is kept open
+// after the swap. PooledDB hands callers the raw *sql.DB, so a caller that
+// grabbed it before a rotation keeps using that exact pool; closing it
+// immediately would turn their next query into "sql: database is closed"
+var secretRotationDrainGrace = 30 * time.Second
+
+// pooledEntry tracks the live *sql.DB for a pool key along with the secret
+// fields it was last opened with, so a rotation watcher can detect change
+type pooledEntry struct {
+	mu     sync.RWMutex
+	db     *sql.DB
+	conf   map[string]string
+	secret map[string]string
+}
+
+func (e *pooledEntry) get() *sql.DB {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.db
+}
+
+// poolKey identifies a pool entry. A static conf is keyed by its dsn as
+// before; a secret_ref-based conf is keyed by the ref itself plus the
+// non-secret connection coordinates, since the dsn isn't known until the
+// secret is resolved and resolving shouldn't happen on every cache hit
+func poolKey(conf map[string]string) (string, error) {
+	if ref := conf["secret_ref"]; ref != "" {
+		return fmt.Sprintf("secret_ref:%s|%s|%s|%s|%s", ref, conf["driver"], conf["host"], conf["port"], conf["database"]), nil
+	}
+	return GetDsn(conf)
+}
+
 // PooledDB get pooled sql.DB
 func PooledDB(conf map[string]string) (*sql.DB, error) {
-	dsn := GetDsn(conf)
-	db, ok := sqlDbs.Load(dsn)
-	if !ok {
-		db2, err := StandaloneDB(conf)
+	key, err := poolKey(conf)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := sqlDbs.Load(key); ok {
+		return v.(*pooledEntry).get(), nil
+	}
+	resolvedConf := conf
+	var secret map[string]string
+	if ref := conf["secret_ref"]; ref != "" {
+		secret, err = ResolveSecretRef(ref)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("resolve secret_ref %s failed: %w", ref, err)
 		}
-		db = db2
-		sqlDbs.Store(dsn, db)
+		resolvedConf = mergeSecret(conf, secret)
 	}
-	return db.(*sql.DB), nil
+	db, err := StandaloneDB(resolvedConf)
+	if err != nil {
+		return nil, err
+	}
+	entry := &pooledEntry{db: db, conf: conf, secret: secret}
+	actual, loaded := sqlDbs.LoadOrStore(key, entry)
+	if loaded {
+		db.Close()
+		return actual.(*pooledEntry).get(), nil
+	}
+	if ref := conf["secret_ref"]; ref != "" && isDynamicSecretRef(ref) {
+		go watchSecretRotation(entry, ref)
+	}
+	return db, nil
+}
+
+// watchSecretRotation periodically re-resolves ref and, if the resolved
+// fields changed, opens a new pool for entry and swaps it in. The old
+// *sql.DB is closed after secretRotationDrainGrace rather than immediately,
+// so callers that already hold the pre-rotation handle get a window to
+// finish in-flight work instead of hitting "sql: database is closed"
+func watchSecretRotation(entry *pooledEntry, ref string) {
+	ticker := time.NewTicker(secretRotationInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		secret, err := ResolveSecretRef(ref)
+		if err != nil {
+			LogRedf("re-resolve secret_ref %s failed: %v", ref, err)
+			continue
+		}
+		entry.mu.RLock()
+		unchanged := mapEqual(entry.secret, secret)
+		entry.mu.RUnlock()
+		if unchanged {
+			continue
+		}
+		newDB, err := StandaloneDB(mergeSecret(entry.conf, secret))
+		if err != nil {
+			LogRedf("open rotated db for secret_ref %s failed: %v", ref, err)
+			continue
+		}
+		entry.mu.Lock()
+		oldDB := entry.db
+		entry.db = newDB
+		entry.secret = secret
+		entry.mu.Unlock()
+		Logf("rotated db credentials for secret_ref %s, closing old pool in %s", ref, secretRotationDrainGrace)
+		time.AfterFunc(secretRotationDrainGrace, func() {
+			if err := oldDB.Close(); err != nil {
+				LogRedf("close rotated-out db for secret_ref %s failed: %v", ref, err)
+			}
+		})
+	}
+}
+
+func mapEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
 }
 
 // StandaloneDB get a standalone db instance
 func StandaloneDB(conf map[string]string) (*sql.DB, error) {
-	dsn := GetDsn(conf)
+	conf, err := resolveConf(conf)
+	if err != nil {
+		return nil, err
+	}
+	dsn, err := GetDsn(conf)
+	if err != nil {
+		return nil, err
+	}
 	Logf("opening standalone %s: %s", conf["driver"], strings.Replace(dsn, conf["password"], "****", 1))
-	return sql.Open(conf["driver"], dsn)
+	db, err := sql.Open(conf["driver"], dsn)
+	if err != nil {
+		return nil, err
+	}
+	if os.Getenv("DTM_AUTOMIGRATE") == "1" {
+		if err := MigrateUp(db, conf["driver"]); err != nil {
+			return nil, fmt.Errorf("auto migrate failed: %w", err)
+		}
+	}
+	return db, nil
 }
 
 // DBExec use raw db to exec
@@ -227,17 +320,128 @@ func DBExec(db DB, sql string, values ...interface{}) (affected int64, rerr erro
 }
 
 // GetDsn get dsn from map config
-func GetDsn(conf map[string]string) string {
+func GetDsn(conf map[string]string) (string, error) {
 	host := MayReplaceLocalhost(conf["host"])
 	driver := conf["driver"]
 	dsn := map[string]string{
 		"mysql": fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=true&loc=Local",
 			conf["user"], conf["password"], host, conf["port"], conf["database"]),
-		"postgres": fmt.Sprintf("host=%s user=%s password=%s dbname='%s' port=%s sslmode=disable",
-			host, conf["user"], conf["password"], conf["database"], conf["port"]),
+		"postgres": fmt.Sprintf("host=%s user=%s password=%s dbname='%s' port=%s sslmode=%s",
+			host, conf["user"], conf["password"], conf["database"], conf["port"], OrString(conf["sslmode"], "disable")),
 	}[driver]
 	PanicIf(dsn == "", fmt.Errorf("unknow driver: %s", driver))
-	return dsn
+	switch driver {
+	case "mysql":
+		tlsName, err := maybeRegisterMysqlTLS(conf)
+		if err != nil {
+			return "", err
+		}
+		if tlsName != "" {
+			dsn += "&tls=" + tlsName
+		}
+	case "postgres":
+		extra, err := postgresSslParams(conf)
+		if err != nil {
+			return "", err
+		}
+		dsn += extra
+	}
+	return dsn, nil
+}
+
+// postgresSslParams validates the configured cert files and returns the
+// extra " key=value" dsn fragment for lib/pq's ssl* parameters
+func postgresSslParams(conf map[string]string) (string, error) {
+	extra := ""
+	for _, key := range []string{"sslrootcert", "sslcert"} {
+		if path := conf[key]; path != "" {
+			if err := checkCertFileExists(path); err != nil {
+				return "", err
+			}
+			extra += fmt.Sprintf(" %s='%s'", key, path)
+		}
+	}
+	if path := conf["sslkey"]; path != "" {
+		if err := checkKeyFilePermission(path); err != nil {
+			return "", err
+		}
+		extra += fmt.Sprintf(" sslkey='%s'", path)
+	}
+	if v := conf["sslsni"]; v != "" {
+		extra += " sslsni=" + v
+	}
+	return extra, nil
+}
+
+// maybeRegisterMysqlTLS validates the configured PEM files and registers a
+// named tls.Config with the mysql driver, returning the name to reference
+// via the dsn's tls= parameter, or "" if no TLS files were configured
+func maybeRegisterMysqlTLS(conf map[string]string) (string, error) {
+	if conf["tls"] == "" && conf["sslrootcert"] == "" && conf["sslcert"] == "" && conf["sslkey"] == "" {
+		return "", nil
+	}
+	if conf["sslrootcert"] == "" && conf["sslcert"] == "" && conf["sslkey"] == "" {
+		// no custom CA/client cert configured, use the driver's built-in names
+		return conf["tls"], nil
+	}
+	tlsConfig := &tls.Config{}
+	if conf["sslrootcert"] != "" {
+		if err := checkCertFileExists(conf["sslrootcert"]); err != nil {
+			return "", err
+		}
+		rootCertPool := x509.NewCertPool()
+		pem, err := os.ReadFile(conf["sslrootcert"])
+		if err != nil {
+			return "", fmt.Errorf("read mysql sslrootcert failed: %w", err)
+		}
+		if ok := rootCertPool.AppendCertsFromPEM(pem); !ok {
+			return "", fmt.Errorf("failed to append mysql sslrootcert pem: %s", conf["sslrootcert"])
+		}
+		tlsConfig.RootCAs = rootCertPool
+	}
+	if conf["sslcert"] != "" || conf["sslkey"] != "" {
+		if conf["sslcert"] == "" || conf["sslkey"] == "" {
+			return "", fmt.Errorf("mysql tls requires both sslcert and sslkey to be set")
+		}
+		if err := checkCertFileExists(conf["sslcert"]); err != nil {
+			return "", err
+		}
+		if err := checkKeyFilePermission(conf["sslkey"]); err != nil {
+			return "", err
+		}
+		cert, err := tls.LoadX509KeyPair(conf["sslcert"], conf["sslkey"])
+		if err != nil {
+			return "", fmt.Errorf("load mysql client cert/key failed: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	name := "dtm-" + conf["database"]
+	if err := mysql.RegisterTLSConfig(name, tlsConfig); err != nil {
+		return "", fmt.Errorf("register mysql tls config failed: %w", err)
+	}
+	return name, nil
+}
+
+// checkCertFileExists returns a clear error instead of panicking or letting
+// the driver fail later when a configured cert file is missing
+func checkCertFileExists(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("ssl cert file not usable: %s: %w", path, err)
+	}
+	return nil
+}
+
+// checkKeyFilePermission rejects world/group-readable private key files,
+// mirroring lib/pq's ssl_permissions check
+func checkKeyFilePermission(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("ssl key file not usable: %s: %w", path, err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("ssl key file %s has overly permissive permissions %#o, must not be group/world readable", path, info.Mode().Perm())
+	}
+	return nil
 }
 
 // CheckResponse 检查Response，返回错误
@@ -17,6 +17,7 @@ import (
 
 func init() {
 	setupFuncs["SagaGormBarrierSetup"] = func(app *gin.Engine) {
+		app.Use(dtmimp.GinLogger())
 		app.POST(BusiAPI+"/SagaBTransOutGorm", common.WrapHandler(sagaGormBarrierTransOut))
 	}
 	addSample("saga_gorm_barrier", func() string {
@@ -35,6 +36,7 @@ func init() {
 
 func sagaGormBarrierTransOut(c *gin.Context) (interface{}, error) {
 	req := reqFrom(c)
+	dtmimp.LoggerFromContext(c.Request.Context()).Infof("saga gorm barrier trans out, amount: %d", req.Amount)
 	barrier := MustBarrierFromGin(c)
 	tx := dbGet().DB.Begin()
 	return dtmcli.MapSuccess, barrier.Call(tx.Statement.ConnPool.(*sql.Tx), func(tx1 *sql.Tx) error {